@@ -0,0 +1,127 @@
+package qpress
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"sync"
+)
+
+// CDCOptions configures content-defined chunking: chunk boundaries are cut
+// by a rolling hash instead of at fixed ChunkSize intervals, so the same run
+// of bytes appearing elsewhere in the file (or in a later backup of it)
+// still produces the same chunk, and therefore the same sha256 in the
+// Manifest.
+type CDCOptions struct {
+	// MinChunkSize is the smallest chunk the chunker will ever cut.
+	MinChunkSize int
+	// MaxChunkSize forces a cut if no content-defined boundary is found
+	// first.
+	MaxChunkSize int
+	// AvgBits sets the target average chunk size to 2^AvgBits bytes: a
+	// boundary is cut when the low AvgBits bits of the rolling hash equal
+	// Magic.
+	AvgBits uint
+	// Magic is the value the masked rolling hash is compared against.
+	Magic uint32
+}
+
+// DefaultCDCOptions returns CDC settings tuned for MySQL .ibd-sized pages:
+// an ~8KiB average chunk, bounded between 2KiB and 64KiB.
+func DefaultCDCOptions() CDCOptions {
+	return CDCOptions{
+		MinChunkSize: 2 * 1024,
+		MaxChunkSize: 64 * 1024,
+		AvgBits:      13,
+		Magic:        0,
+	}
+}
+
+// cdcWindow is the width, in bytes, of the rolling hash's sliding window.
+const cdcWindow = 64
+
+// buzhashTable holds one pseudo-random uint32 per possible input byte.
+// Seeded deterministically so the same input always cuts the same chunks.
+var buzhashTable [256]uint32
+
+func init() {
+	rng := rand.New(rand.NewSource(1))
+	for i := range buzhashTable {
+		buzhashTable[i] = rng.Uint32()
+	}
+}
+
+func rol32(x uint32, by uint) uint32 {
+	by %= 32
+	return (x << by) | (x >> (32 - by))
+}
+
+// cdcCut scans data for the first content-defined chunk boundary, per
+// opts. It returns the chunk length and true once either a rolling-hash
+// boundary is found at or past MinChunkSize, or MaxChunkSize is reached; it
+// returns false if data doesn't yet hold a full chunk, so the caller should
+// buffer more input before trying again.
+func cdcCut(data []byte, opts CDCOptions) (chunkLen int, found bool) {
+	limit := opts.MaxChunkSize
+	if limit > len(data) {
+		limit = len(data)
+	}
+	if limit < opts.MinChunkSize {
+		return 0, false
+	}
+
+	mask := uint32(1)<<opts.AvgBits - 1
+	var h uint32
+	for i := 0; i < limit; i++ {
+		in := buzhashTable[data[i]]
+		if i < cdcWindow {
+			h = rol32(h, 1) ^ in
+		} else {
+			out := rol32(buzhashTable[data[i-cdcWindow]], cdcWindow)
+			h = rol32(h, 1) ^ in ^ out
+		}
+		if i+1 >= opts.MinChunkSize && h&mask == opts.Magic {
+			return i + 1, true
+		}
+	}
+	if limit >= opts.MaxChunkSize {
+		return opts.MaxChunkSize, true
+	}
+	return 0, false
+}
+
+// ManifestEntry records one chunk's place in both the original file and the
+// archive, so a later writer can recognize a chunk it already has on disk
+// by its SHA256 and skip re-writing it.
+type ManifestEntry struct {
+	Path             string `json:"path"`
+	LogicalOffset    int64  `json:"logicalOffset"`
+	LogicalSize      int64  `json:"logicalSize"`
+	SHA256           string `json:"sha256"`
+	CompressedOffset int64  `json:"compressedOffset"`
+	CompressedSize   int64  `json:"compressedSize"`
+}
+
+// Manifest is the sidecar manifest a Writer emits alongside an archive when
+// EnableManifest is used: every chunk written, in the order it was written.
+type Manifest struct {
+	mu      sync.Mutex
+	Entries []ManifestEntry `json:"entries"`
+}
+
+func (m *Manifest) add(e ManifestEntry) {
+	m.mu.Lock()
+	m.Entries = append(m.Entries, e)
+	m.mu.Unlock()
+}
+
+// writeFile marshals the manifest as JSON to path.
+func (m *Manifest) writeFile(path string) error {
+	m.mu.Lock()
+	data, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0640)
+}
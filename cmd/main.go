@@ -32,7 +32,7 @@ func main() {
 	fmt.Println("filename: ", inputFile.Name())
 	fmt.Println("filename: ", inputFile.Name())
 	var limitSize int64 = 1024 * 1024
-	isPartial, err := archiveFile.Decompress(inputFile, "./tmp/", limitSize)
+	isPartial, err := archiveFile.Decompress(inputFile, "./tmp/", limitSize, nil)
 	if err != nil {
 		fmt.Printf("decompress qpress file failed: %s\n", err.Error())
 		os.Exit(1)
@@ -45,13 +45,18 @@ to compress.
 */
 
 import (
+	"archive/tar"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
+	"hash/adler32"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	quicklz "github.com/Hiroko103/go-quicklz"
 
@@ -91,27 +96,14 @@ type Target interface {
 	ReadHeader(r io.Reader) (err error)
 }
 
-// Reader provides sequential access to chunks from an qpress. Each chunk returned represents a
-// contiguous set of bytes for a file compressed in the qpress file. The Next method advances the stream
-// and returns the next chunk in the archive. Each archive then acts as a reader for its contiguous set of bytes
-type Reader struct {
-	reader io.Reader
-}
-
-// NewReader creates a new Reader by wrapping the provided reader
-func NewReader(reader io.Reader) *Reader {
-	return &Reader{reader: reader}
-}
-
-// Next advances the Reader and returns the next DataBlock.
-func (r *Reader) NextBlock() (dataBlock *DataBlock, err error) {
-	return
-}
-
 // ARCHIVE =        ARCHIVEHEADER + (1 or more of UPDIR | DOWNDIR | FILE)
 type ArchiveFile struct {
 	ArchiveHeader
 	Targets []Target
+	// StrictChecksums makes a per-block adler32 or recovery-info mismatch a
+	// hard error. When false, mismatches are appended to Warnings instead.
+	StrictChecksums bool
+	Warnings        []string
 }
 
 // ARCHIVEHEADER =  "qpress10" + (ui64)(chunk size of decompressed packets)
@@ -139,6 +131,17 @@ type FileTarget struct {
 	TargetHeader
 	DataBlocks []*DataBlock
 	FileTrailer
+
+	// wg tracks the file's outstanding block-decompression jobs in the
+	// shared worker pool. Decompress returns once every block has been
+	// submitted; Wait blocks until they have actually finished.
+	wg sync.WaitGroup
+}
+
+// Wait blocks until every block decompression job submitted for this file
+// has finished.
+func (t *FileTarget) Wait() {
+	t.wg.Wait()
 }
 
 type DataBlock struct {
@@ -188,34 +191,113 @@ func (t *TargetType) ReadType(r io.Reader) (err error) {
 	return
 }
 
+// ExtractOptions controls how ArchiveFile.Decompress parallelizes block
+// decompression. When Pool is nil, Decompress creates and owns a pool sized
+// by Workers (default 10) for the duration of the call; every FileTarget in
+// the archive shares it instead of each spinning up its own.
+type ExtractOptions struct {
+	Workers int
+	Pool    *pond.WorkerPool
+}
+
+// decompressState carries everything the shared worker pool needs across
+// FileTargets: the pool itself, a bounded pool of reusable decompressed
+// chunk buffers, and first-error propagation via context cancellation.
+type decompressState struct {
+	ctx               context.Context
+	cancel            context.CancelFunc
+	pool              *pond.WorkerPool
+	bufPool           *chunkBufferPool
+	compressedBufPool *chunkBufferPool
+
+	errOnce sync.Once
+	err     error
+}
+
+func (ds *decompressState) setErr(err error) {
+	ds.errOnce.Do(func() {
+		ds.err = err
+		ds.cancel()
+	})
+}
+
+func newDecompressState(opts *ExtractOptions) (ds *decompressState, ownsPool bool) {
+	workers := 10
+	pool := (*pond.WorkerPool)(nil)
+	if opts != nil {
+		if opts.Workers > 0 {
+			workers = opts.Workers
+		}
+		pool = opts.Pool
+	}
+	ownsPool = pool == nil
+	if ownsPool {
+		pool = pond.New(workers, workers*4, pond.Strategy(pond.Balanced()))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &decompressState{
+		ctx:               ctx,
+		cancel:            cancel,
+		pool:              pool,
+		bufPool:           newChunkBufferPool(workers * 4),
+		compressedBufPool: newChunkBufferPool(workers * 4),
+	}, ownsPool
+}
+
 // Decompress reads the archive file header and then processes each target
-// until it finds the end of the file.
-func (af *ArchiveFile) Decompress(r io.Reader, baseDIR string, limitSize int64) (isPartial bool, err error) {
+// until it finds the end of the file. Block decompression for every file in
+// the archive is submitted to a single shared worker pool (see
+// ExtractOptions), so many small files no longer serialize on pool
+// setup/teardown.
+func (af *ArchiveFile) Decompress(r io.Reader, baseDIR string, limitSize int64, opts *ExtractOptions) (isPartial bool, err error) {
 	// Read the archive file header.
 	err = af.ReadFileHeader(r)
 	if err != nil {
 		return false, fmt.Errorf("read file header failed: %s", err.Error())
 	}
-	err = os.Mkdir("tmp", 0755)
-	if err != nil && !os.IsExist(err) {
+	err = os.MkdirAll(baseDIR, 0755)
+	if err != nil {
 		return false, err
 	}
 
+	ds, ownsPool := newDecompressState(opts)
+	if ownsPool {
+		defer ds.pool.StopAndWait()
+	}
+	defer ds.cancel()
+
+	// dirStack tracks the directory the decompressor currently writes into,
+	// pushed on DOWNDIR and popped on UPDIR.
+	dirStack := []string{baseDIR}
+	var fileTargets []*FileTarget
+
+	finish := func() (bool, error) {
+		for _, ft := range fileTargets {
+			ft.wg.Wait()
+		}
+		if ds.err != nil {
+			return false, ds.err
+		}
+		return false, nil
+	}
+
 	tt := new(TargetType)
 	for {
 		// Read the target type.
 		err = tt.ReadType(r)
 		if err == io.EOF {
-			return false, nil
+			return finish()
 		}
 		if err != nil {
 			return false, fmt.Errorf("read type %s failed: %s", tt[:], err.Error())
 		}
 
+		currentPath := dirStack[len(dirStack)-1]
+
 		// Process the target based on its type.
 		switch tt[0] {
 		case 0:
-			return false, nil
+			return finish()
 		case TypeDown:
 			DownTarget := new(DownTarget)
 			DownTarget.TargetType = *tt
@@ -223,8 +305,17 @@ func (af *ArchiveFile) Decompress(r io.Reader, baseDIR string, limitSize int64)
 			if err != nil {
 				return false, err
 			}
+			name := string(DownTarget.Name)
+			if err = validateEntryName(name); err != nil {
+				return false, err
+			}
+			currentPath = filepath.Join(currentPath, name)
+			err = os.MkdirAll(currentPath, 0755)
+			if err != nil {
+				return false, err
+			}
+			dirStack = append(dirStack, currentPath)
 			af.Targets = append(af.Targets, DownTarget)
-			return false, fmt.Errorf("unsupport down directory")
 		case TypeUp:
 			UpTarget := new(UpTarget)
 			UpTarget.TargetType = *tt
@@ -232,35 +323,69 @@ func (af *ArchiveFile) Decompress(r io.Reader, baseDIR string, limitSize int64)
 			if err != nil {
 				return false, err
 			}
+			if len(dirStack) == 1 {
+				return false, fmt.Errorf("up directory failed: no directory open")
+			}
+			dirStack = dirStack[:len(dirStack)-1]
 			af.Targets = append(af.Targets, UpTarget)
-			return false, fmt.Errorf("unsupport up directory")
 		case TypeFile:
 			FileTarget := &FileTarget{}
 			FileTarget.TargetType = *tt
-			err = FileTarget.Decompress(r, baseDIR, limitSize)
+			err = FileTarget.Decompress(ds, r, currentPath, limitSize, af.StrictChecksums, &af.Warnings)
 			if err != nil {
 				if strings.HasPrefix(err.Error(), "partial decompress to limited size") {
+					// The file that tripped the limit may be left
+					// truncated, but every file target submitted before it
+					// already queued all of its blocks and must be allowed
+					// to finish before the deferred ds.cancel() tears down
+					// the shared pool's context.
+					for _, ft := range fileTargets {
+						ft.wg.Wait()
+					}
 					return true, nil
 				}
 				return false, err
 			}
 			af.Targets = append(af.Targets, FileTarget)
+			fileTargets = append(fileTargets, FileTarget)
 		default:
 			return false, fmt.Errorf("unknown type: %s", tt[:])
 		}
 	}
 }
 
+// validateEntryName rejects DOWNDIR/FILEHEADER names that are absolute or
+// that escape the directory they're extracted into (zip-slip).
+func validateEntryName(name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid entry name: empty")
+	}
+	if filepath.IsAbs(name) {
+		return fmt.Errorf("invalid entry name %q: absolute path", name)
+	}
+	cleaned := filepath.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid entry name %q: escapes base directory", name)
+	}
+	return nil
+}
+
+// DecompressStream decompresses the archive onto w as a single tar stream,
+// so that archives containing directories (which have no single sensible
+// flat output) still have one coherent output target.
 func (af *ArchiveFile) DecompressStream(r io.Reader, w io.Writer, limitSize int64) (isPartial bool, err error) {
 	// Read the archive file header.
 	err = af.ReadFileHeader(r)
 	if err != nil {
 		return false, fmt.Errorf("read file header failed: %s", err.Error())
 	}
-	err = os.Mkdir("tmp", 0755)
-	if err != nil && !os.IsExist(err) {
-		return false, err
-	}
+
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	// dirStack tracks the tar path the decompressor currently writes into,
+	// pushed on DOWNDIR and popped on UPDIR.
+	dirStack := []string{""}
 
 	tt := new(TargetType)
 	for {
@@ -273,6 +398,8 @@ func (af *ArchiveFile) DecompressStream(r io.Reader, w io.Writer, limitSize int6
 			return false, fmt.Errorf("read type %s failed: %s", tt[:], err.Error())
 		}
 
+		currentPath := dirStack[len(dirStack)-1]
+
 		// Process the target based on its type.
 		switch tt[0] {
 		case 0:
@@ -284,8 +411,21 @@ func (af *ArchiveFile) DecompressStream(r io.Reader, w io.Writer, limitSize int6
 			if err != nil {
 				return false, err
 			}
+			name := string(DownTarget.Name)
+			if err = validateEntryName(name); err != nil {
+				return false, err
+			}
+			currentPath = path.Join(currentPath, name)
+			err = tw.WriteHeader(&tar.Header{
+				Name:     currentPath + "/",
+				Typeflag: tar.TypeDir,
+				Mode:     0755,
+			})
+			if err != nil {
+				return false, fmt.Errorf("write tar dir header failed: %s", err.Error())
+			}
+			dirStack = append(dirStack, currentPath)
 			af.Targets = append(af.Targets, DownTarget)
-			return false, fmt.Errorf("unsupport down directory")
 		case TypeUp:
 			UpTarget := new(UpTarget)
 			UpTarget.TargetType = *tt
@@ -293,12 +433,15 @@ func (af *ArchiveFile) DecompressStream(r io.Reader, w io.Writer, limitSize int6
 			if err != nil {
 				return false, err
 			}
+			if len(dirStack) == 1 {
+				return false, fmt.Errorf("up directory failed: no directory open")
+			}
+			dirStack = dirStack[:len(dirStack)-1]
 			af.Targets = append(af.Targets, UpTarget)
-			return false, fmt.Errorf("unsupport up directory")
 		case TypeFile:
 			FileTarget := &FileTarget{}
 			FileTarget.TargetType = *tt
-			err = FileTarget.DecompressStream(r, w, limitSize)
+			err = FileTarget.DecompressStream(r, tw, currentPath, limitSize, af.StrictChecksums, &af.Warnings)
 			if err != nil {
 				if strings.HasPrefix(err.Error(), "partial decompress to limited size") {
 					return true, nil
@@ -355,14 +498,22 @@ func (t *DownTarget) Read(r io.Reader) (err error) {
 	return t.ReadHeader(r)
 }
 
-func (t *FileTarget) Decompress(r io.Reader, baseDIR string, limitSize int64) (err error) {
+// Decompress reads the file's blocks and submits their decompression to
+// ds's shared worker pool. It returns once every block has been submitted
+// and the FILETRAILER has been read, not once they've finished decompressing
+// — call Wait (or ds's caller waiting on all FileTargets) for that.
+func (t *FileTarget) Decompress(ds *decompressState, r io.Reader, baseDIR string, limitSize int64, strict bool, warnings *[]string) (err error) {
 	var offset int64
+	var recoverInfo *RecoverInfo
 
 	err = t.ReadHeader(r)
 	if err != nil {
 		return err
 	}
 
+	if err = validateEntryName(string(t.Name)); err != nil {
+		return err
+	}
 	targetFilePath := filepath.Join(baseDIR, string(t.Name))
 	_, err = os.Stat(targetFilePath)
 	if err != nil && !os.IsNotExist(err) {
@@ -375,14 +526,19 @@ func (t *FileTarget) Decompress(r io.Reader, baseDIR string, limitSize int64) (e
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	var maxWorkers = 10
-	var maxDataBlockQueue = 40
-
-	pool := pond.New(maxWorkers, maxDataBlockQueue, pond.Strategy(pond.Balanced()))
-
-	defer pool.StopAndWait()
+	// Add(1) up front guards against the watcher below observing a
+	// transient zero count (and closing f) before the loop below has
+	// submitted its first block job; it's released once every block for
+	// this file has been read and submitted.
+	t.wg.Add(1)
+	defer t.wg.Done()
+
+	// f is closed once every block job submitted for this file has
+	// finished, which can be well after Decompress itself returns.
+	go func() {
+		t.wg.Wait()
+		f.Close()
+	}()
 
 	// decompress blocks
 	tt := new(TargetType)
@@ -396,25 +552,52 @@ func (t *FileTarget) Decompress(r io.Reader, baseDIR string, limitSize int64) (e
 		}
 		switch tt[0] {
 		case TypeNew:
-			block := NewDataBlock()
+			block := &DataBlock{
+				BlockType:       TargetType{TypeNew},
+				CompressedChunk: ds.compressedBufPool.get(),
+			}
 			err = block.ReadBlock(r)
 			if err != nil {
+				ds.compressedBufPool.put(block.CompressedChunk)
 				return fmt.Errorf("decompress block failed: %w", err)
 			}
+			if err = verifyBlock(block, string(t.Name), &recoverInfo, strict, warnings); err != nil {
+				ds.compressedBufPool.put(block.CompressedChunk)
+				return err
+			}
 			if limitSize > 0 && offset+block.DecompressedSize > limitSize {
+				ds.compressedBufPool.put(block.CompressedChunk)
 				return fmt.Errorf("partial decompress to limited size %d", limitSize)
 			}
 			block.DecompressedOffset = offset
-			pool.Submit(func() {
-				decompressedChunk := make([]byte, block.DecompressedSize)
+			t.wg.Add(1)
+			ds.pool.Submit(func() {
+				defer t.wg.Done()
+				defer ds.compressedBufPool.put(block.CompressedChunk)
+				// pond's default panic handler only logs a stack trace, so
+				// without this a panic here (e.g. a corrupt chunk) would
+				// silently drop the block instead of failing Decompress.
+				defer func() {
+					if r := recover(); r != nil {
+						ds.setErr(fmt.Errorf("panic decompressing block: %v", r))
+					}
+				}()
+				select {
+				case <-ds.ctx.Done():
+					return
+				default:
+				}
+				decompressedChunk := ds.bufPool.get()
+				defer ds.bufPool.put(decompressedChunk)
+				decompressedChunk = decompressedChunk[:block.DecompressedSize]
 				err := block.DecompressChunk(&decompressedChunk)
 				if err != nil {
-					fmt.Printf("decompress chunk failed: %+v", err)
+					ds.setErr(fmt.Errorf("decompress chunk failed: %w", err))
 					return
 				}
 				_, err = f.WriteAt(decompressedChunk, block.DecompressedOffset)
 				if err != nil {
-					fmt.Printf("write failed: %+v", err)
+					ds.setErr(fmt.Errorf("write failed: %w", err))
 					return
 				}
 			})
@@ -424,6 +607,9 @@ func (t *FileTarget) Decompress(r io.Reader, baseDIR string, limitSize int64) (e
 			if err != nil {
 				return fmt.Errorf("read trailer failed: %s", err.Error())
 			}
+			if err = verifyTrailer(t, string(t.Name), recoverInfo, strict, warnings); err != nil {
+				return err
+			}
 			return nil
 		default:
 			return fmt.Errorf("invalid block header, 'N' or 'E' not found, get: %d", tt[:])
@@ -431,13 +617,22 @@ func (t *FileTarget) Decompress(r io.Reader, baseDIR string, limitSize int64) (e
 	}
 }
 
-func (t *FileTarget) DecompressStream(r io.Reader, w io.Writer, limitSize int64) (err error) {
+// DecompressStream decompresses the file's blocks into memory, then writes
+// them to tw as a single tar entry under currentPath. The full size must be
+// known up front to write the tar header, so unlike Decompress this cannot
+// stream blocks straight through to the output.
+func (t *FileTarget) DecompressStream(r io.Reader, tw *tar.Writer, currentPath string, limitSize int64, strict bool, warnings *[]string) (err error) {
 	var offset int64
+	var content bytes.Buffer
+	var recoverInfo *RecoverInfo
 
 	err = t.ReadHeader(r)
 	if err != nil {
 		return err
 	}
+	if err = validateEntryName(string(t.Name)); err != nil {
+		return err
+	}
 
 	// decompress blocks
 	tt := new(TargetType)
@@ -456,6 +651,9 @@ func (t *FileTarget) DecompressStream(r io.Reader, w io.Writer, limitSize int64)
 			if err != nil {
 				return fmt.Errorf("decompress block failed: %w", err)
 			}
+			if err = verifyBlock(block, string(t.Name), &recoverInfo, strict, warnings); err != nil {
+				return err
+			}
 			if limitSize > 0 && offset+block.DecompressedSize > limitSize {
 				return fmt.Errorf("partial decompress to limited size %d", limitSize)
 			}
@@ -465,7 +663,7 @@ func (t *FileTarget) DecompressStream(r io.Reader, w io.Writer, limitSize int64)
 			if err != nil {
 				return fmt.Errorf("decompress chunk failed: %+v", err)
 			}
-			_, err = w.Write(decompressedChunk)
+			_, err = content.Write(decompressedChunk)
 			if err != nil {
 				return fmt.Errorf("write failed: %+v", err)
 			}
@@ -475,6 +673,23 @@ func (t *FileTarget) DecompressStream(r io.Reader, w io.Writer, limitSize int64)
 			if err != nil {
 				return fmt.Errorf("read trailer failed: %s", err.Error())
 			}
+			if err = verifyTrailer(t, string(t.Name), recoverInfo, strict, warnings); err != nil {
+				return err
+			}
+			hdr := &tar.Header{
+				Name:     path.Join(currentPath, string(t.Name)),
+				Typeflag: tar.TypeReg,
+				Mode:     0640,
+				Size:     int64(content.Len()),
+			}
+			err = tw.WriteHeader(hdr)
+			if err != nil {
+				return fmt.Errorf("write tar file header failed: %s", err.Error())
+			}
+			_, err = tw.Write(content.Bytes())
+			if err != nil {
+				return fmt.Errorf("write tar file content failed: %s", err.Error())
+			}
 			return nil
 		default:
 			return fmt.Errorf("invalid block header, 'N' or 'E' not found, get: %d", tt[:])
@@ -492,6 +707,31 @@ func NewDataBlock() *DataBlock {
 	}
 }
 
+// chunkBufferPool is a bounded pool of pre-allocated, ChunkSize+400-capacity
+// buffers shared by every block decompression job in the worker pool, so
+// decompressing a block doesn't allocate a fresh slice every time.
+type chunkBufferPool struct {
+	bufs chan []byte
+}
+
+func newChunkBufferPool(n int) *chunkBufferPool {
+	p := &chunkBufferPool{bufs: make(chan []byte, n)}
+	for i := 0; i < n; i++ {
+		p.bufs <- make([]byte, ChunkSize, ChunkSize+400)
+	}
+	return p
+}
+
+// get takes a buffer from the pool, blocking until one is free.
+func (p *chunkBufferPool) get() []byte {
+	return <-p.bufs
+}
+
+// put returns a buffer to the pool, resetting it to its full capacity.
+func (p *chunkBufferPool) put(b []byte) {
+	p.bufs <- b[:cap(b)]
+}
+
 func (t *DataBlock) InitBlock() error {
 	t.CompressedChunk = make([]byte, ChunkSize, ChunkSize+400)
 	t.CompressedSize = 0
@@ -547,16 +787,87 @@ func (t *DataBlock) ReadChunk(r io.Reader) (err error) {
 	return
 }
 
+// DecompressChunk decompresses t.CompressedChunk into *decompressedChunk.
+// The vendored QuickLZ decompressor's word-at-a-time copy can write up to
+// 400 bytes past the logical end of a block (the same slack the compressed
+// chunk buffers are already given), so *decompressedChunk is grown to
+// DecompressedSize+400 before the call — reusing its existing capacity when
+// there's room, or allocating if not — and truncated back to
+// DecompressedSize once decompression returns.
 func (t *DataBlock) DecompressChunk(decompressedChunk *[]byte) (err error) {
 	qlz, err := quicklz.New(quicklz.COMPRESSION_LEVEL_1, quicklz.STREAMING_BUFFER_0)
 	if err != nil {
 		return err
 	}
-	// Decompress data to DecompressedChunk
-	_, err = qlz.Decompress(&t.CompressedChunk, decompressedChunk)
+	dst := *decompressedChunk
+	want := int(t.DecompressedSize) + 400
+	if cap(dst) >= want {
+		dst = dst[:want]
+	} else {
+		dst = make([]byte, want)
+	}
+	// Decompress data to dst
+	_, err = qlz.Decompress(&t.CompressedChunk, &dst)
 	if err != nil {
 		return fmt.Errorf("decompress: %s", err.Error())
 	}
+	*decompressedChunk = dst[:t.DecompressedSize]
+	return nil
+}
+
+// VerifyChecksum compares the block's stored adler32 against the adler32 of
+// the compressed chunk actually read off the wire, as called out by the
+// DATABLOCK format comment above.
+func (t *DataBlock) VerifyChecksum() error {
+	return verifyAdler32(t.CompressedChunk[:t.CompressedSize], t.Checksum)
+}
+
+// verifyAdler32 compares the adler32 of data against the little-endian
+// adler32 stored in want.
+func verifyAdler32(data []byte, want [4]byte) error {
+	got := adler32.Checksum(data)
+	wantU32 := binary.LittleEndian.Uint32(want[:])
+	if got != wantU32 {
+		return fmt.Errorf("checksum mismatch: got %08x want %08x", got, wantU32)
+	}
+	return nil
+}
+
+// verifyBlock checks a DATABLOCK's adler32 and tracks its recovery info so
+// it can later be compared against the FILETRAILER's. Mismatches are either
+// returned as a hard error (strict) or appended to warnings.
+func verifyBlock(block *DataBlock, fileName string, recoverInfo **RecoverInfo, strict bool, warnings *[]string) error {
+	if err := block.VerifyChecksum(); err != nil {
+		if strict {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+		*warnings = append(*warnings, fmt.Sprintf("%s: %s", fileName, err.Error()))
+	}
+	if *recoverInfo == nil {
+		ri := block.RecoverInfo
+		*recoverInfo = &ri
+	} else if block.RecoverInfo != **recoverInfo {
+		msg := fmt.Sprintf("%s: recovery info mismatch between blocks", fileName)
+		if strict {
+			return fmt.Errorf("%s", msg)
+		}
+		*warnings = append(*warnings, msg)
+	}
+	return nil
+}
+
+// verifyTrailer checks that the FILETRAILER's recovery info matches the
+// recovery info seen across the file's DATABLOCKs, the self-consistency
+// guarantee the qpress format's name implies.
+func verifyTrailer(t *FileTarget, fileName string, recoverInfo *RecoverInfo, strict bool, warnings *[]string) error {
+	if recoverInfo == nil || t.FileTrailer.RecoverInfo == *recoverInfo {
+		return nil
+	}
+	msg := fmt.Sprintf("%s: recovery info mismatch between block and trailer", fileName)
+	if strict {
+		return fmt.Errorf("%s", msg)
+	}
+	*warnings = append(*warnings, msg)
 	return nil
 }
 
@@ -601,3 +912,24 @@ func ReadLengthU32EncodedString(r io.Reader) (readBytesLen uint32, readBytes []b
 	err = binary.Read(r, nil, &readBytes)
 	return readBytesLen, readBytes, err
 }
+
+// WriteTerminator writes the terminating null byte that follows a
+// length-encoded string in DOWNDIR and FILEHEADER targets.
+func WriteTerminator(w io.Writer) (err error) {
+	_, err = w.Write(Terminator)
+	return err
+}
+
+// WriteLengthU32EncodedString writes s as a (ui32)(size) + (bytes) +
+// (char)0 encoded string, the inverse of ReadLengthU32EncodedString.
+func WriteLengthU32EncodedString(w io.Writer, s string) (err error) {
+	err = binary.Write(w, binary.LittleEndian, uint32(len(s)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte(s))
+	if err != nil {
+		return err
+	}
+	return WriteTerminator(w)
+}
@@ -0,0 +1,318 @@
+package qpress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+)
+
+// Reader provides random access to the files in a qpress archive, modeled
+// after archive/zip.Reader: it scans the archive once to build an index of
+// every file's blocks, so any one file can be opened and read without
+// decompressing the files before it.
+type Reader struct {
+	r     io.ReaderAt
+	size  int64
+	Files []*FileEntry
+
+	// chunkSize is the archive's own chunk size, read from its
+	// ARCHIVEHEADER. It's recorded here rather than relied on via the
+	// package-level ChunkSize var so two Readers opened concurrently on
+	// archives written with different chunk sizes don't race on it.
+	chunkSize uint64
+
+	// StrictChecksums makes a per-block adler32 mismatch a hard error from
+	// FileEntry.Open/OpenAt. When false, mismatches are appended to Warnings
+	// instead.
+	StrictChecksums bool
+
+	warningsMu sync.Mutex
+	Warnings   []string
+}
+
+func (zr *Reader) addWarning(msg string) {
+	zr.warningsMu.Lock()
+	zr.Warnings = append(zr.Warnings, msg)
+	zr.warningsMu.Unlock()
+}
+
+// FileEntry describes one file target in the archive: its path, and the
+// location of its FILEHEADER and DATABLOCKs within the underlying
+// io.ReaderAt.
+type FileEntry struct {
+	Name             string
+	HeaderOffset     int64
+	DecompressedSize int64
+	blocks           []blockIndex
+	r                io.ReaderAt
+	parent           *Reader
+}
+
+// blockIndex locates one DATABLOCK's compressed chunk within the archive,
+// and the cumulative decompressed offset it starts at within its file.
+type blockIndex struct {
+	blockStart         int64
+	chunkOffset        int64
+	compressedSize     int64
+	decompressedSize   int64
+	decompressedOffset int64
+	checksum           [4]byte
+}
+
+// rawSize is the number of bytes the raw (still QuickLZ-compressed) DATABLOCK
+// occupies on disk: the type byte, StarterTail, RecoverInfo, Checksum and
+// the compressed packet itself.
+func (b blockIndex) rawSize() int64 {
+	return (b.chunkOffset - b.blockStart) + b.compressedSize
+}
+
+// countingReader wraps an io.Reader, tracking how many bytes have been
+// consumed so a scan can record absolute offsets as it parses sequentially.
+type countingReader struct {
+	r   io.Reader
+	off int64
+}
+
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.r.Read(p)
+	cr.off += int64(n)
+	return n, err
+}
+
+// NewReader scans r to build a Reader indexing every file in the archive.
+// r must span exactly size bytes of qpress10 archive data.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	cr := &countingReader{r: io.NewSectionReader(r, 0, size)}
+
+	// The header is parsed here rather than with ReadFileHeader, which would
+	// also overwrite the package-level ChunkSize.
+	ah := &ArchiveHeader{}
+	if _, err := io.ReadFull(cr, ah.Magic[:]); err != nil {
+		return nil, fmt.Errorf("read file header failed: read magic failed: %s", err.Error())
+	}
+	if !bytes.Equal(ah.Magic[:], QpressMagic) {
+		return nil, fmt.Errorf("read file header failed: invalid magic: %s", ah.Magic)
+	}
+	if err := binary.Read(cr, binary.LittleEndian, &ah.ChunkSize); err != nil {
+		return nil, fmt.Errorf("read file header failed: read chunk size failed: %s", err.Error())
+	}
+
+	zr := &Reader{r: r, size: size, chunkSize: ah.ChunkSize}
+	dirStack := []string{""}
+
+	tt := new(TargetType)
+	for {
+		typeOffset := cr.off
+		err := tt.ReadType(cr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read type %s failed: %s", tt[:], err.Error())
+		}
+
+		currentPath := dirStack[len(dirStack)-1]
+
+		switch tt[0] {
+		case 0:
+			return zr, nil
+		case TypeDown:
+			down := new(DownTarget)
+			down.TargetType = *tt
+			if err = down.Read(cr); err != nil {
+				return nil, err
+			}
+			currentPath = path.Join(currentPath, string(down.Name))
+			dirStack = append(dirStack, currentPath)
+		case TypeUp:
+			up := new(UpTarget)
+			up.TargetType = *tt
+			if err = up.Read(cr); err != nil {
+				return nil, err
+			}
+			if len(dirStack) == 1 {
+				return nil, fmt.Errorf("up directory failed: no directory open")
+			}
+			dirStack = dirStack[:len(dirStack)-1]
+		case TypeFile:
+			entry, err := scanFileEntry(cr, r, currentPath, typeOffset, zr.chunkSize)
+			if err != nil {
+				return nil, err
+			}
+			entry.parent = zr
+			zr.Files = append(zr.Files, entry)
+		default:
+			return nil, fmt.Errorf("unknown type: %s", tt[:])
+		}
+	}
+	return zr, nil
+}
+
+// scanFileEntry reads a FILEHEADER and its DATABLOCKs/FILETRAILER from cr,
+// recording each block's offset within r so FileEntry.Open can later read
+// them back lazily.
+func scanFileEntry(cr *countingReader, r io.ReaderAt, dir string, headerOffset int64, chunkSize uint64) (*FileEntry, error) {
+	th := &TargetHeader{}
+	if err := th.ReadHeader(cr); err != nil {
+		return nil, err
+	}
+
+	entry := &FileEntry{
+		Name:         path.Join(dir, string(th.Name)),
+		HeaderOffset: headerOffset,
+		r:            r,
+	}
+
+	var decOffset int64
+	tt := new(TargetType)
+	for {
+		blockStart := cr.off
+		if err := tt.ReadType(cr); err != nil {
+			return nil, fmt.Errorf("read type %s failed: %w", tt[:], err)
+		}
+		switch tt[0] {
+		case TypeNew:
+			block := &DataBlock{}
+			if err := block.ReadStarterTail(cr); err != nil {
+				return nil, err
+			}
+			if err := block.ReadRecoverInfo(cr); err != nil {
+				return nil, err
+			}
+			if err := block.ReadChecksum(cr); err != nil {
+				return nil, err
+			}
+			chunkOffset := cr.off
+			block.CompressedChunk = make([]byte, chunkSize, chunkSize+400)
+			if err := block.ReadChunk(cr); err != nil {
+				return nil, fmt.Errorf("read chunk failed: %w", err)
+			}
+			entry.blocks = append(entry.blocks, blockIndex{
+				blockStart:         blockStart,
+				chunkOffset:        chunkOffset,
+				compressedSize:     block.CompressedSize,
+				decompressedSize:   block.DecompressedSize,
+				decompressedOffset: decOffset,
+				checksum:           block.Checksum,
+			})
+			decOffset += block.DecompressedSize
+		case TypeEnd:
+			trailer := &FileTrailer{}
+			if err := trailer.ReadTrailer(cr); err != nil {
+				return nil, fmt.Errorf("read trailer failed: %s", err.Error())
+			}
+			entry.DecompressedSize = decOffset
+			return entry, nil
+		default:
+			return nil, fmt.Errorf("invalid block header, 'N' or 'E' not found, get: %d", tt[:])
+		}
+	}
+}
+
+// decompressBlock reads and decompresses a single DATABLOCK's chunk,
+// verifying its adler32 against the checksum recorded at scan time.
+func (e *FileEntry) decompressBlock(blk blockIndex) ([]byte, error) {
+	compressed := make([]byte, blk.compressedSize)
+	_, err := io.ReadFull(io.NewSectionReader(e.r, blk.chunkOffset, blk.compressedSize), compressed)
+	if err != nil {
+		return nil, fmt.Errorf("read compressed chunk failed: %s", err.Error())
+	}
+	if err = verifyAdler32(compressed, blk.checksum); err != nil {
+		if e.parent != nil && e.parent.StrictChecksums {
+			return nil, fmt.Errorf("%s: %w", e.Name, err)
+		}
+		if e.parent != nil {
+			e.parent.addWarning(fmt.Sprintf("%s: %s", e.Name, err.Error()))
+		}
+	}
+	block := &DataBlock{CompressedChunk: compressed}
+	decompressed := make([]byte, blk.decompressedSize)
+	if err = block.DecompressChunk(&decompressed); err != nil {
+		return nil, err
+	}
+	return decompressed, nil
+}
+
+// fileEntryReader streams an entry's blocks, decompressing one at a time.
+type fileEntryReader struct {
+	entry *FileEntry
+	next  int
+	buf   []byte
+}
+
+// Open returns a reader that lazily decompresses the entry's blocks as they
+// are read, in order.
+func (e *FileEntry) Open() (io.ReadCloser, error) {
+	return &fileEntryReader{entry: e}, nil
+}
+
+func (fr *fileEntryReader) Read(p []byte) (n int, err error) {
+	for len(fr.buf) == 0 {
+		if fr.next >= len(fr.entry.blocks) {
+			return 0, io.EOF
+		}
+		decompressed, err := fr.entry.decompressBlock(fr.entry.blocks[fr.next])
+		if err != nil {
+			return 0, err
+		}
+		fr.next++
+		fr.buf = decompressed
+	}
+	n = copy(p, fr.buf)
+	fr.buf = fr.buf[n:]
+	return n, nil
+}
+
+func (fr *fileEntryReader) Close() error {
+	return nil
+}
+
+// OpenRaw returns the entry's still-QuickLZ-compressed DATABLOCK stream
+// verbatim, including the NEWBNEWB framing, recovery info and adler32 of
+// each block, but not the FILEHEADER or FILETRAILER. It lets a DATABLOCK be
+// spliced into another archive without ever being decompressed.
+func (e *FileEntry) OpenRaw() io.Reader {
+	readers := make([]io.Reader, len(e.blocks))
+	for i, blk := range e.blocks {
+		readers[i] = io.NewSectionReader(e.r, blk.blockStart, blk.rawSize())
+	}
+	return io.MultiReader(readers...)
+}
+
+// OpenAt returns a reader over the n decompressed bytes of the entry
+// starting at off, decompressing only the blocks that overlap that range.
+// The starting block is located by binary-searching the cumulative
+// decompressed offset table.
+func (e *FileEntry) OpenAt(off, n int64) (io.Reader, error) {
+	if off < 0 || n < 0 || off+n > e.DecompressedSize {
+		return nil, fmt.Errorf("OpenAt out of range: off=%d n=%d size=%d", off, n, e.DecompressedSize)
+	}
+
+	start := sort.Search(len(e.blocks), func(i int) bool {
+		return e.blocks[i].decompressedOffset+e.blocks[i].decompressedSize > off
+	})
+
+	out := make([]byte, 0, n)
+	pos := off
+	remaining := n
+	for i := start; i < len(e.blocks) && remaining > 0; i++ {
+		blk := e.blocks[i]
+		decompressed, err := e.decompressBlock(blk)
+		if err != nil {
+			return nil, err
+		}
+		skip := pos - blk.decompressedOffset
+		avail := decompressed[skip:]
+		if int64(len(avail)) > remaining {
+			avail = avail[:remaining]
+		}
+		out = append(out, avail...)
+		pos += int64(len(avail))
+		remaining -= int64(len(avail))
+	}
+	return bytes.NewReader(out), nil
+}
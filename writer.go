@@ -0,0 +1,367 @@
+package qpress
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"path"
+	"sync"
+
+	quicklz "github.com/Hiroko103/go-quicklz"
+
+	"github.com/alitto/pond"
+)
+
+// Writer writes a qpress10 archive. Targets must be written in the same
+// order the format expects them to be read back in: CreateDir/CloseDir
+// calls bracket a directory's contents, and CreateFile returns a stream
+// that must be closed before the next target is started.
+type Writer struct {
+	w         *countingWriter
+	chunkSize uint64
+	depth     int
+
+	// cdc, when non-nil, makes every CreateFile stream use content-defined
+	// chunking instead of fixed ChunkSize framing. See
+	// EnableContentDefinedChunking.
+	cdc *CDCOptions
+
+	// manifest and manifestPath are set by EnableManifest; Close writes the
+	// accumulated manifest to manifestPath as JSON.
+	manifest     *Manifest
+	manifestPath string
+}
+
+// countingWriter wraps an io.Writer, tracking how many bytes have been
+// written so chunk manifest entries can record their absolute offset.
+type countingWriter struct {
+	w   io.Writer
+	off int64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.off += int64(n)
+	return n, err
+}
+
+// NewWriter creates a Writer using DefaultChunkSize for the chunks it emits.
+func NewWriter(w io.Writer) (*Writer, error) {
+	return NewWriterSize(w, DefaultChunkSize)
+}
+
+// NewWriterSize creates a Writer that chunks file contents at chunkSize
+// bytes before compressing and writing the archive header.
+func NewWriterSize(w io.Writer, chunkSize uint64) (*Writer, error) {
+	aw := &Writer{w: &countingWriter{w: w}, chunkSize: chunkSize}
+	if err := aw.writeFileHeader(); err != nil {
+		return nil, fmt.Errorf("write file header failed: %s", err.Error())
+	}
+	return aw, nil
+}
+
+// EnableContentDefinedChunking switches every subsequent CreateFile stream
+// from fixed ChunkSize framing to content-defined chunking: chunk
+// boundaries are cut at rolling-hash-defined content boundaries instead, so
+// an unchanged run of bytes elsewhere in the file still produces the same
+// chunk (and so the same sha256) it did before, which Manifest entries can
+// then be used to detect and skip during an incremental restore.
+func (aw *Writer) EnableContentDefinedChunking(opts CDCOptions) {
+	aw.cdc = &opts
+}
+
+// EnableManifest makes Close write a sidecar manifest of every chunk's
+// {path, logicalOffset, logicalSize, sha256, compressedOffset,
+// compressedSize} to path as JSON.
+func (aw *Writer) EnableManifest(path string) {
+	aw.manifest = &Manifest{}
+	aw.manifestPath = path
+}
+
+// Close finalizes the archive: if EnableManifest was called, it writes the
+// accumulated manifest to its configured path. It does not close the
+// underlying io.Writer.
+func (aw *Writer) Close() error {
+	if aw.manifest == nil {
+		return nil
+	}
+	return aw.manifest.writeFile(aw.manifestPath)
+}
+
+func (aw *Writer) writeFileHeader() (err error) {
+	_, err = aw.w.Write(QpressMagic)
+	if err != nil {
+		return err
+	}
+	return binary.Write(aw.w, binary.LittleEndian, aw.chunkSize)
+}
+
+// CreateDir emits a DOWNDIR target, entering a new directory. It must be
+// matched by a later CloseDir.
+func (aw *Writer) CreateDir(name string) (err error) {
+	_, err = aw.w.Write([]byte{TypeDown})
+	if err != nil {
+		return err
+	}
+	err = WriteLengthU32EncodedString(aw.w, name)
+	if err != nil {
+		return err
+	}
+	aw.depth++
+	return nil
+}
+
+// CloseDir emits an UPDIR target, leaving the directory opened by the
+// matching CreateDir.
+func (aw *Writer) CloseDir() (err error) {
+	if aw.depth == 0 {
+		return fmt.Errorf("close dir failed: no directory open")
+	}
+	_, err = aw.w.Write([]byte{TypeUp})
+	if err != nil {
+		return err
+	}
+	aw.depth--
+	return nil
+}
+
+// CreateFile emits a FILEHEADER and returns a writer that chunks, compresses
+// and streams the file's contents as DATABLOCKs. The returned writer must be
+// closed to emit the FILETRAILER before the next target is written.
+func (aw *Writer) CreateFile(name string) (io.WriteCloser, error) {
+	_, err := aw.w.Write([]byte{TypeFile})
+	if err != nil {
+		return nil, err
+	}
+	err = WriteLengthU32EncodedString(aw.w, name)
+	if err != nil {
+		return nil, err
+	}
+	return newFileWriter(aw.w, aw.chunkSize, name, aw.cdc, aw.manifest), nil
+}
+
+// CreateRaw emits a FILEHEADER and returns a writer that passes its input
+// straight through to the archive, framed as a FILETRAILER on Close. It is
+// for callers that already have QuickLZ-compressed DATABLOCKs on hand, such
+// as CopyFile, and don't want them re-framed or re-compressed.
+func (aw *Writer) CreateRaw(name string) (io.WriteCloser, error) {
+	_, err := aw.w.Write([]byte{TypeFile})
+	if err != nil {
+		return nil, err
+	}
+	err = WriteLengthU32EncodedString(aw.w, name)
+	if err != nil {
+		return nil, err
+	}
+	return &rawFileWriter{w: aw.w}, nil
+}
+
+// rawFileWriter writes pre-framed DATABLOCK bytes straight through to the
+// archive and emits the FILETRAILER on Close.
+type rawFileWriter struct {
+	w io.Writer
+}
+
+func (rw *rawFileWriter) Write(p []byte) (int, error) {
+	return rw.w.Write(p)
+}
+
+func (rw *rawFileWriter) Close() (err error) {
+	_, err = rw.w.Write(TrailerStarter)
+	if err != nil {
+		return err
+	}
+	_, err = rw.w.Write(EmptyRecoverInfo)
+	return err
+}
+
+// CopyFile splices src's already-compressed DATABLOCKs straight into the
+// archive, without decompressing and recompressing them. This makes
+// merging, splitting or re-rooting an archive essentially I/O-bound.
+func (aw *Writer) CopyFile(src *FileEntry) (err error) {
+	rw, err := aw.CreateRaw(path.Base(src.Name))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(rw, src.OpenRaw())
+	if err != nil {
+		return err
+	}
+	return rw.Close()
+}
+
+// fileWriterMaxWorkers and fileWriterMaxDataBlockQueue mirror the default
+// worker pool sizing used by ArchiveFile.Decompress (see newDecompressState).
+var (
+	fileWriterMaxWorkers        = 10
+	fileWriterMaxDataBlockQueue = 40
+)
+
+// fileWriter buffers Write calls into chunks (fixed ChunkSize, or
+// content-defined boundaries when cdc is set), compresses each chunk in the
+// worker pool, and writes out DATABLOCKs in order.
+type fileWriter struct {
+	w         *countingWriter
+	pool      *pond.WorkerPool
+	buf       []byte
+	name      string
+	chunkSize uint64
+	cdc       *CDCOptions
+
+	manifest      *Manifest
+	logicalOffset int64
+
+	mu   sync.Mutex
+	wg   sync.WaitGroup
+	err  error
+	prev chan struct{}
+}
+
+func newFileWriter(w *countingWriter, chunkSize uint64, name string, cdc *CDCOptions, manifest *Manifest) *fileWriter {
+	done := make(chan struct{})
+	close(done)
+	return &fileWriter{
+		w:         w,
+		pool:      pond.New(fileWriterMaxWorkers, fileWriterMaxDataBlockQueue, pond.Strategy(pond.Balanced())),
+		buf:       make([]byte, 0, chunkSize),
+		name:      name,
+		chunkSize: chunkSize,
+		cdc:       cdc,
+		manifest:  manifest,
+		prev:      done,
+	}
+}
+
+func (fw *fileWriter) Write(p []byte) (n int, err error) {
+	if err = fw.getErr(); err != nil {
+		return 0, err
+	}
+	n = len(p)
+	fw.buf = append(fw.buf, p...)
+	if fw.cdc != nil {
+		for {
+			cut, ok := cdcCut(fw.buf, *fw.cdc)
+			if !ok {
+				break
+			}
+			chunk := fw.buf[:cut]
+			fw.buf = append([]byte{}, fw.buf[cut:]...)
+			fw.submitChunk(chunk)
+		}
+	} else {
+		for uint64(len(fw.buf)) >= fw.chunkSize {
+			chunk := fw.buf[:fw.chunkSize]
+			fw.buf = append([]byte{}, fw.buf[fw.chunkSize:]...)
+			fw.submitChunk(chunk)
+		}
+	}
+	return n, fw.getErr()
+}
+
+// Close flushes any buffered remainder as a final chunk, waits for all
+// queued compression jobs to finish, and writes the FILETRAILER.
+func (fw *fileWriter) Close() (err error) {
+	if len(fw.buf) > 0 {
+		fw.submitChunk(fw.buf)
+		fw.buf = nil
+	}
+	fw.wg.Wait()
+	fw.pool.StopAndWait()
+	if err = fw.getErr(); err != nil {
+		return err
+	}
+	_, err = fw.w.Write(TrailerStarter)
+	if err != nil {
+		return err
+	}
+	_, err = fw.w.Write(EmptyRecoverInfo)
+	return err
+}
+
+// submitChunk compresses chunk in the worker pool and writes the resulting
+// DATABLOCK once every earlier chunk has been written, preserving archive
+// order while compression itself proceeds in parallel.
+func (fw *fileWriter) submitChunk(chunk []byte) {
+	data := append([]byte{}, chunk...)
+	logicalOffset := fw.logicalOffset
+	fw.logicalOffset += int64(len(data))
+	done := make(chan struct{})
+	prev := fw.prev
+	fw.prev = done
+	fw.wg.Add(1)
+	fw.pool.Submit(func() {
+		defer fw.wg.Done()
+		defer close(done)
+		block, err := compressChunk(data)
+		var sum [sha256.Size]byte
+		if fw.manifest != nil {
+			sum = sha256.Sum256(data)
+		}
+		<-prev
+		if err != nil {
+			fw.setErr(err)
+			return
+		}
+		if fw.getErr() != nil {
+			return
+		}
+		compressedOffset := fw.w.off
+		if _, werr := fw.w.Write(block); werr != nil {
+			fw.setErr(werr)
+			return
+		}
+		if fw.manifest != nil {
+			fw.manifest.add(ManifestEntry{
+				Path:             fw.name,
+				LogicalOffset:    logicalOffset,
+				LogicalSize:      int64(len(data)),
+				SHA256:           hex.EncodeToString(sum[:]),
+				CompressedOffset: compressedOffset,
+				CompressedSize:   int64(len(block)),
+			})
+		}
+	})
+}
+
+func (fw *fileWriter) setErr(err error) {
+	fw.mu.Lock()
+	if fw.err == nil {
+		fw.err = err
+	}
+	fw.mu.Unlock()
+}
+
+func (fw *fileWriter) getErr() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.err
+}
+
+// compressChunk QuickLZ-compresses a single chunk and frames it as a
+// complete DATABLOCK: "NEWBNEWB" + recovery info + adler32 + compressed
+// packet.
+func compressChunk(decompressedChunk []byte) (block []byte, err error) {
+	qlz, err := quicklz.New(quicklz.COMPRESSION_LEVEL_1, quicklz.STREAMING_BUFFER_0)
+	if err != nil {
+		return nil, err
+	}
+	compressed := make([]byte, len(decompressedChunk)+400)
+	n, err := qlz.Compress(&decompressedChunk, &compressed)
+	if err != nil {
+		return nil, fmt.Errorf("compress: %s", err.Error())
+	}
+	compressed = compressed[:n]
+
+	block = make([]byte, 0, len(BlockStarter)+len(EmptyRecoverInfo)+4+len(compressed))
+	block = append(block, BlockStarter...)
+	block = append(block, EmptyRecoverInfo...)
+	checksum := adler32.Checksum(compressed)
+	checksumBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(checksumBytes, checksum)
+	block = append(block, checksumBytes...)
+	block = append(block, compressed...)
+	return block, nil
+}
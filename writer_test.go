@@ -0,0 +1,161 @@
+package qpress
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSingleFileArchive builds a qpress10 archive with one FILE target
+// named name, using chunkSize and the given content.
+func writeSingleFileArchive(t *testing.T, chunkSize uint64, name string, content []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	aw, err := NewWriterSize(&buf, chunkSize)
+	if err != nil {
+		t.Fatalf("NewWriterSize failed: %s", err)
+	}
+	fw, err := aw.CreateFile(name)
+	if err != nil {
+		t.Fatalf("CreateFile failed: %s", err)
+	}
+	if _, err = fw.Write(content); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if err = fw.Close(); err != nil {
+		t.Fatalf("Close file failed: %s", err)
+	}
+	if err = aw.Close(); err != nil {
+		t.Fatalf("Close archive failed: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// decompressSingleFile decompresses data into a fresh temp directory and
+// returns the bytes of the single file it contains.
+func decompressSingleFile(t *testing.T, data []byte, name string) []byte {
+	t.Helper()
+	dir := t.TempDir()
+	af := &ArchiveFile{}
+	isPartial, err := af.Decompress(bytes.NewReader(data), dir, 0, nil)
+	if err != nil {
+		t.Fatalf("Decompress failed: %s", err)
+	}
+	if isPartial {
+		t.Fatalf("Decompress unexpectedly reported partial")
+	}
+	got, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("read decompressed file failed: %s", err)
+	}
+	return got
+}
+
+// TestWriterRoundTrip covers an empty file, a file smaller than the chunk
+// size, and a file spanning several chunks at a non-default ChunkSize, and
+// checks each round-trips through ArchiveFile.Decompress unchanged.
+func TestWriterRoundTrip(t *testing.T) {
+	cases := []struct {
+		name      string
+		chunkSize uint64
+		content   []byte
+	}{
+		{"empty", 8, nil},
+		{"tiny", 8, []byte("hi")},
+		{"multi-chunk", 8, bytes.Repeat([]byte("0123456789"), 50)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := writeSingleFileArchive(t, c.chunkSize, c.name, c.content)
+			got := decompressSingleFile(t, data, c.name)
+			if !bytes.Equal(got, c.content) {
+				t.Fatalf("round-trip mismatch: got %d bytes, want %d bytes", len(got), len(c.content))
+			}
+		})
+	}
+}
+
+// TestWriterCopyFile splices a file's already-compressed DATABLOCK stream
+// into a new archive with CopyFile and checks that the copy round-trips to
+// the original content.
+func TestWriterCopyFile(t *testing.T) {
+	content := bytes.Repeat([]byte("copy-me "), 100)
+	srcData := writeSingleFileArchive(t, 32, "orig.txt", content)
+
+	zr, err := NewReader(bytes.NewReader(srcData), int64(len(srcData)))
+	if err != nil {
+		t.Fatalf("NewReader failed: %s", err)
+	}
+	if len(zr.Files) != 1 {
+		t.Fatalf("expected 1 file entry, got %d", len(zr.Files))
+	}
+
+	var dstBuf bytes.Buffer
+	dw, err := NewWriter(&dstBuf)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %s", err)
+	}
+	if err = dw.CopyFile(zr.Files[0]); err != nil {
+		t.Fatalf("CopyFile failed: %s", err)
+	}
+	if err = dw.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	got := decompressSingleFile(t, dstBuf.Bytes(), "orig.txt")
+	if !bytes.Equal(got, content) {
+		t.Fatalf("copied archive round-trip mismatch: got %d bytes, want %d bytes", len(got), len(content))
+	}
+}
+
+// TestDecompressPartialLimitLeavesPriorFilesIntact exercises the
+// ExtractOptions/limitSize path: a large file followed by a small file that
+// alone trips limitSize should still come back with the first file fully
+// written, not truncated to zero bytes.
+func TestDecompressPartialLimitLeavesPriorFilesIntact(t *testing.T) {
+	first := bytes.Repeat([]byte("A"), 2*1024*1024)
+	second := bytes.Repeat([]byte("B"), 4096)
+
+	var buf bytes.Buffer
+	aw, err := NewWriterSize(&buf, DefaultChunkSize)
+	if err != nil {
+		t.Fatalf("NewWriterSize failed: %s", err)
+	}
+	for _, f := range []struct {
+		name    string
+		content []byte
+	}{{"first.bin", first}, {"second.bin", second}} {
+		fw, err := aw.CreateFile(f.name)
+		if err != nil {
+			t.Fatalf("CreateFile failed: %s", err)
+		}
+		if _, err = fw.Write(f.content); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+		if err = fw.Close(); err != nil {
+			t.Fatalf("Close file failed: %s", err)
+		}
+	}
+	if err = aw.Close(); err != nil {
+		t.Fatalf("Close archive failed: %s", err)
+	}
+
+	dir := t.TempDir()
+	af := &ArchiveFile{}
+	isPartial, err := af.Decompress(bytes.NewReader(buf.Bytes()), dir, int64(len(first))+1024, nil)
+	if err != nil {
+		t.Fatalf("Decompress failed: %s", err)
+	}
+	if !isPartial {
+		t.Fatalf("expected partial decompress, got full")
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "first.bin"))
+	if err != nil {
+		t.Fatalf("read first.bin failed: %s", err)
+	}
+	if !bytes.Equal(got, first) {
+		t.Fatalf("first.bin truncated by a later file's limit cutoff: got %d bytes, want %d", len(got), len(first))
+	}
+}